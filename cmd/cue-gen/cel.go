@@ -0,0 +1,177 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// celValidationAttr is the CUE attribute authors use to attach a CEL rule to
+// a field or struct whose constraint OpenAPI cannot express at all, such as
+// a disjunction guarded by a discriminator field or a matchN:
+//
+//	mode: "STRICT" | "PERMISSIVE"
+//	jwks?: string
+//	#Auth: {
+//		mode: string
+//		jwks?: string
+//	} @validation(rule="self.mode != 'STRICT' || has(self.jwks)", message="jwks is required in STRICT mode")
+const celValidationAttr = "validation"
+
+// addCELValidations walks j in step with the CUE value it was generated
+// from and appends an x-kubernetes-validations entry to each node that
+// needs one: either a cross-field bound derived straight from the CUE
+// constraint (a simple bound's operand already has an OpenAPI equivalent -
+// minimum/maximum/pattern - so only bounds whose operand references another
+// field are derived here), or an explicit @validation(rule=...,message=...)
+// attribute for constraints no general derivation can reach - matchN,
+// disjunctions gated by a discriminator.
+func addCELValidations(j *apiextv1.JSONSchemaProps, v cue.Value) {
+	if rule, message, ok := celRule(v); ok {
+		j.XValidations = append(j.XValidations, apiextv1.ValidationRule{Rule: rule, Message: message})
+	}
+
+	for _, rule := range deriveCELRules(v) {
+		j.XValidations = append(j.XValidations, rule)
+	}
+
+	iter, err := v.Fields(cue.Optional(true))
+	if err != nil {
+		return
+	}
+
+	for iter.Next() {
+		p, ok := j.Properties[iter.Selector().String()]
+		if !ok {
+			continue
+		}
+		addCELValidations(&p, iter.Value())
+		j.Properties[iter.Selector().String()] = p
+	}
+
+	if j.Items != nil && j.Items.Schema != nil {
+		if elem := v.LookupPath(cue.MakePath(cue.AnyIndex)); elem.Exists() {
+			addCELValidations(j.Items.Schema, elem)
+		}
+	}
+}
+
+// celRule extracts the rule/message pair from a @validation(...) attribute
+// on v, if one is present.
+func celRule(v cue.Value) (rule, message string, ok bool) {
+	attr := v.Attribute(celValidationAttr)
+	if attr.Err() != nil {
+		return "", "", false
+	}
+
+	rule, found, err := attr.Lookup(0, "rule")
+	if err != nil || !found || rule == "" {
+		return "", "", false
+	}
+	message, _, _ = attr.Lookup(0, "message")
+
+	return rule, message, true
+}
+
+// deriveCELRules derives x-kubernetes-validations rules from the bound
+// expressions in v's conjunction that OpenAPI's minimum/maximum/exclusive
+// keywords can't express: a bound whose operand is another field rather than
+// a literal. `self.replicas <= self.maxReplicas` has no OpenAPI equivalent
+// at all, so it's emitted as CEL; `self <= 100` is already covered by
+// `maximum: 100` and is deliberately left for CUE's OpenAPI encoder to emit
+// instead of being duplicated here.
+func deriveCELRules(v cue.Value) []apiextv1.ValidationRule {
+	op, operands := v.Expr()
+
+	var rules []apiextv1.ValidationRule
+	switch op {
+	case cue.AndOp:
+		for _, operand := range operands {
+			rules = append(rules, deriveCELRules(operand)...)
+		}
+	case cue.LessThanOp, cue.LessThanEqualOp, cue.GreaterThanOp, cue.GreaterThanEqualOp, cue.NotEqualOp:
+		if len(operands) == 1 {
+			if rule, ok := crossFieldRule(op, operands[0]); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules
+}
+
+// crossFieldRule builds a CEL rule comparing self to another field in the
+// same struct, provided bound is a reference to that field rather than a
+// literal - a literal bound (e.g. `<=100`) is already captured in the
+// OpenAPI schema as minimum/maximum/etc. and deriving it again here would
+// just duplicate it.
+func crossFieldRule(op cue.Op, bound cue.Value) (apiextv1.ValidationRule, bool) {
+	if bound.IsConcrete() {
+		return apiextv1.ValidationRule{}, false
+	}
+
+	field, ok := fieldSelector(bound)
+	if !ok {
+		return apiextv1.ValidationRule{}, false
+	}
+
+	symbol, ok := celOperator(op)
+	if !ok {
+		return apiextv1.ValidationRule{}, false
+	}
+
+	return apiextv1.ValidationRule{
+		Rule:    fmt.Sprintf("self %s self.%s", symbol, field),
+		Message: fmt.Sprintf("must be %s self.%s", symbol, field),
+	}, true
+}
+
+// fieldSelector extracts the sibling field name referenced by v's syntax, if
+// v is a plain field reference (`maxReplicas`) rather than a computed
+// expression.
+func fieldSelector(v cue.Value) (string, bool) {
+	switch n := v.Syntax().(type) {
+	case *ast.Ident:
+		return n.Name, true
+	case *ast.SelectorExpr:
+		if ident, ok := n.Sel.(*ast.Ident); ok {
+			return ident.Name, true
+		}
+	}
+
+	return "", false
+}
+
+// celOperator maps a CUE comparison operator to its CEL symbol.
+func celOperator(op cue.Op) (string, bool) {
+	switch op {
+	case cue.LessThanOp:
+		return "<", true
+	case cue.LessThanEqualOp:
+		return "<=", true
+	case cue.GreaterThanOp:
+		return ">", true
+	case cue.GreaterThanEqualOp:
+		return ">=", true
+	case cue.NotEqualOp:
+		return "!=", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestAddCELValidationsFromAttribute(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+auth: {
+	mode: string
+	jwks?: string
+} @validation(rule="self.mode != 'STRICT' || has(self.jwks)", message="jwks required in STRICT mode")
+`)
+	if err := v.Err(); err != nil {
+		t.Fatalf("CompileString: %v", err)
+	}
+
+	j := &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"auth": {
+				Type: "object",
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"mode": {Type: "string"},
+					"jwks": {Type: "string"},
+				},
+			},
+		},
+	}
+
+	addCELValidations(j, v)
+
+	auth := j.Properties["auth"]
+	if len(auth.XValidations) != 1 {
+		t.Fatalf("expected one CEL rule on auth, got %v", auth.XValidations)
+	}
+	if got, want := auth.XValidations[0].Rule, "self.mode != 'STRICT' || has(self.jwks)"; got != want {
+		t.Errorf("rule = %q, want %q", got, want)
+	}
+	if got, want := auth.XValidations[0].Message, "jwks required in STRICT mode"; got != want {
+		t.Errorf("message = %q, want %q", got, want)
+	}
+}
+
+func TestAddCELValidationsDerivesCrossFieldBound(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`
+replicas: int
+maxReplicas: >=replicas
+`)
+	if err := v.Err(); err != nil {
+		t.Fatalf("CompileString: %v", err)
+	}
+
+	j := &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"replicas":    {Type: "integer"},
+			"maxReplicas": {Type: "integer"},
+		},
+	}
+
+	addCELValidations(j, v)
+
+	maxReplicas := j.Properties["maxReplicas"]
+	if len(maxReplicas.XValidations) != 1 {
+		t.Fatalf("expected one derived CEL rule on maxReplicas, got %v", maxReplicas.XValidations)
+	}
+	if got, want := maxReplicas.XValidations[0].Rule, "self >= self.replicas"; got != want {
+		t.Errorf("rule = %q, want %q", got, want)
+	}
+}
+
+func TestAddCELValidationsLiteralBoundNotDerived(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`replicas: <=100`)
+	if err := v.Err(); err != nil {
+		t.Fatalf("CompileString: %v", err)
+	}
+
+	j := &apiextv1.JSONSchemaProps{Type: "integer"}
+	addCELValidations(j, v.LookupPath(cue.ParsePath("replicas")))
+
+	if len(j.XValidations) != 0 {
+		t.Fatalf("expected a literal bound not to be re-derived as CEL, got %v", j.XValidations)
+	}
+}
+
+func TestCelRuleNoAttribute(t *testing.T) {
+	ctx := cuecontext.New()
+	v := ctx.CompileString(`mode: string`)
+	if err := v.Err(); err != nil {
+		t.Fatalf("CompileString: %v", err)
+	}
+
+	rule, message, ok := celRule(v.LookupPath(cue.ParsePath("mode")))
+	if ok {
+		t.Fatalf("expected no rule without an attribute, got rule=%q message=%q", rule, message)
+	}
+}
@@ -19,12 +19,18 @@ import (
 	"fmt"
 	"log"
 
+	"cuelang.org/go/cue"
 	"cuelang.org/go/encoding/openapi"
 	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	structuralschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema"
+	celschema "k8s.io/apiextensions-apiserver/pkg/apiserver/schema/cel"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/kube-openapi/pkg/spec3"
 	"k8s.io/utils/pointer"
 	crdutil "sigs.k8s.io/controller-tools/pkg/crd"
+
+	"istio.io/tools/pkg/crdpatch"
 )
 
 const (
@@ -68,10 +74,13 @@ func patchEmtpyTypesToObjectType(j *apiextv1.JSONSchemaProps) {
 	}
 }
 
-// Build CRDs based on the configuration and schema.
+// Build CRDs based on the configuration and schema. When companionOpenAPIV3Enabled
+// opts c in, the returned document is the standalone OpenAPI v3 description
+// of c's final, patched schema, ready to be written out alongside the CRD
+// YAML; it is nil otherwise.
 //
 //nolint:staticcheck,interfacer,lll
-func completeCRD(c *apiextv1.CustomResourceDefinition, versionSchemas map[string]*openapi.OrderedMap, statusSchema *openapi.OrderedMap, preserveUnknownFields map[string][]string) {
+func completeCRD(c *apiextv1.CustomResourceDefinition, versionSchemas map[string]*openapi.OrderedMap, statusSchema *openapi.OrderedMap, preserveUnknownFields map[string][]string, markers MarkerConfig, overlays crdpatch.Config, cueSchemas map[string]cue.Value, disableCEL map[string]bool, embeddedObjectMetaPaths map[string][]string, companionOpenAPIV3Enabled map[string]bool) *spec3.OpenAPI {
 	for i, version := range c.Spec.Versions {
 
 		b, err := versionSchemas[version.Name].MarshalJSON()
@@ -92,8 +101,36 @@ func completeCRD(c *apiextv1.CustomResourceDefinition, versionSchemas map[string
 			}
 		}
 
+		// apply the broader marker vocabulary (+listType, +listMapKey,
+		// +mapType, +embeddedResource, +nullable, +default,
+		// +kubebuilder:validation:XValidation) for server-side-apply
+		// semantics CUE-generated OpenAPI cannot express on its own.
+		if ms, ok := markers[c.Name][version.Name]; ok {
+			if err := applyMarkers(j, ms); err != nil {
+				log.Fatalf("Cannot apply schema markers for %v: %v", c.Name, err)
+			}
+		}
+
 		patchEmtpyTypesToObjectType(j)
 
+		// give embedded pod/service templates a real, validated metadata
+		// sub-schema, when the CRD opts in; the templates keep
+		// x-kubernetes-preserve-unknown-fields for their other fields.
+		if paths, ok := embeddedObjectMetaPaths[c.Name]; ok {
+			if err := spliceEmbeddedObjectMeta(j, paths); err != nil {
+				log.Fatalf("Cannot generate embedded ObjectMeta for %v: %v", c.Name, err)
+			}
+		}
+
+		// translate CUE constraints OpenAPI cannot express (cross-field
+		// relationships, discriminated disjunctions, matchN) into CEL rules,
+		// unless the CRD has opted out.
+		if !disableCEL[c.Name] {
+			if v, ok := cueSchemas[version.Name]; ok {
+				addCELValidations(j, v)
+			}
+		}
+
 		version.Schema = &apiextv1.CustomResourceValidation{OpenAPIV3Schema: &apiextv1.JSONSchemaProps{
 			Type: "object",
 			Properties: map[string]apiextv1.JSONSchemaProps{
@@ -123,6 +160,10 @@ func completeCRD(c *apiextv1.CustomResourceDefinition, versionSchemas map[string
 			version.Schema.OpenAPIV3Schema.Properties["status"] = *status
 		}
 
+		if report := repairNonStructural(version.Schema.OpenAPIV3Schema); len(report) > 0 {
+			fmt.Printf("Repaired non-structural schema for %v:\n  %v\n", c.Name, report)
+		}
+
 		fmt.Printf("Checking if the schema is structural for %v \n", c.Name)
 		if err = validateStructural(version.Schema.OpenAPIV3Schema); err != nil {
 			log.Fatal(err)
@@ -131,11 +172,37 @@ func completeCRD(c *apiextv1.CustomResourceDefinition, versionSchemas map[string
 		c.Spec.Versions[i] = version
 	}
 
+	// apply user-supplied overlays (JSON Patch / JSON Merge Patch) on top of
+	// the generated schema, then re-validate: a bad overlay should fail
+	// generation loudly instead of shipping an invalid CRD.
+	if len(overlays) > 0 {
+		if err := crdpatch.Apply(overlays, c); err != nil {
+			log.Fatal(err)
+		}
+
+		for _, version := range c.Spec.Versions {
+			if err := validateStructural(version.Schema.OpenAPIV3Schema); err != nil {
+				log.Fatalf("overlay produced a non-structural schema for %v/%v: %v", c.Name, version.Name, err)
+			}
+		}
+	}
+
 	c.APIVersion = apiextv1.SchemeGroupVersion.String()
 	c.Kind = "CustomResourceDefinition"
 
 	// marshal to an empty field in the output
 	c.Status = apiextv1.CustomResourceDefinitionStatus{}
+
+	var doc *spec3.OpenAPI
+	if companionOpenAPIV3Enabled[c.Name] {
+		var err error
+		doc, err = companionOpenAPIV3(c)
+		if err != nil {
+			log.Fatalf("Cannot build companion OpenAPI v3 document for %v: %v", c.Name, err)
+		}
+	}
+
+	return doc
 }
 
 func validateStructural(s *apiextv1.JSONSchemaProps) error {
@@ -153,5 +220,34 @@ func validateStructural(s *apiextv1.JSONSchemaProps) error {
 		return fmt.Errorf("schema is not structural: %v", errs.ToAggregate().Error())
 	}
 
+	results, err := celschema.Compile(r, true, celschema.PerCallLimit)
+	if err != nil {
+		return fmt.Errorf("cannot compile x-kubernetes-validations rules: %v", err)
+	}
+	if hasCompileError(results) {
+		return fmt.Errorf("schema has invalid x-kubernetes-validations rules: %v", compileErrors(results))
+	}
+
 	return nil
 }
+
+// hasCompileError reports whether any of the emitted x-kubernetes-validations
+// rules failed to compile.
+func hasCompileError(results []celschema.CompilationResult) bool {
+	for _, r := range results {
+		if r.Error != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func compileErrors(results []celschema.CompilationResult) error {
+	var errs []error
+	for _, r := range results {
+		if r.Error != nil {
+			errs = append(errs, r.Error)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
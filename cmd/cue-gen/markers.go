@@ -0,0 +1,150 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/utils/pointer"
+)
+
+// Marker is a single schema-editing directive applied to the field at Path,
+// mirroring the vocabulary controller-tools recognizes on Go structs -
+// +listType, +listMapKey, +mapType, +embeddedResource, +nullable, +default,
+// +kubebuilder:validation:XValidation - for the fields CUE-generated OpenAPI
+// cannot express server-side-apply semantics for.
+type Marker struct {
+	// Path addresses the field the marker applies to, dot-separated with
+	// `[*]` on a segment meaning "every element of this array", e.g.
+	// `spec.http[*].route[*].destination`.
+	Path string `json:"path"`
+	// Name is the marker: "listType", "listMapKey", "mapType",
+	// "embeddedResource", "nullable", "default" or "XValidation".
+	Name string `json:"name"`
+	// Value is the marker's argument. Its shape depends on Name: a string
+	// for listType/listMapKey/mapType, the literal default value for
+	// "default", and a {"rule": ..., "message": ...} object for
+	// "XValidation". Unused for "embeddedResource" and "nullable".
+	Value interface{} `json:"value,omitempty"`
+}
+
+// MarkerConfig maps a CRD name to the markers applied to each of its
+// versions, the same shape the `preserveUnknownFields` config already uses.
+type MarkerConfig map[string]map[string][]Marker
+
+// applyMarkers applies every marker configured for crdName/version to j.
+func applyMarkers(j *apiextv1.JSONSchemaProps, markers []Marker) error {
+	for _, m := range markers {
+		if err := applyMarker(j, strings.Split(m.Path, "."), m); err != nil {
+			return fmt.Errorf("marker %+v: %v", m, err)
+		}
+	}
+	return nil
+}
+
+func applyMarker(j *apiextv1.JSONSchemaProps, path []string, m Marker) error {
+	if len(path) == 0 {
+		return setMarker(j, m)
+	}
+
+	name := path[0]
+	wildcard := strings.HasSuffix(name, "[*]")
+	if wildcard {
+		name = strings.TrimSuffix(name, "[*]")
+	}
+
+	field, ok := j.Properties[name]
+	if !ok {
+		return fmt.Errorf("no property %q", name)
+	}
+
+	target := &field
+	if wildcard {
+		if field.Items == nil || field.Items.Schema == nil {
+			return fmt.Errorf("%q is not a list", name)
+		}
+		target = field.Items.Schema
+	}
+
+	if err := applyMarker(target, path[1:], m); err != nil {
+		return err
+	}
+
+	j.Properties[name] = field
+	return nil
+}
+
+func setMarker(j *apiextv1.JSONSchemaProps, m Marker) error {
+	switch m.Name {
+	case "listType":
+		v, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("listType needs a string value")
+		}
+		j.XListType = &v
+	case "listMapKey":
+		v, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("listMapKey needs a string value")
+		}
+		j.XListMapKeys = append(j.XListMapKeys, v)
+	case "mapType":
+		v, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("mapType needs a string value")
+		}
+		j.XMapType = &v
+	case "embeddedResource":
+		j.XEmbeddedResource = true
+		j.Type = "object"
+		j.XPreserveUnknownFields = pointer.Bool(true)
+	case "nullable":
+		j.Nullable = true
+	case "default":
+		b, err := json.Marshal(m.Value)
+		if err != nil {
+			return fmt.Errorf("cannot marshal default: %v", err)
+		}
+		j.Default = &apiextv1.JSON{Raw: b}
+	case "XValidation":
+		rule, message, err := validationRuleArgs(m.Value)
+		if err != nil {
+			return err
+		}
+		j.XValidations = append(j.XValidations, apiextv1.ValidationRule{Rule: rule, Message: message})
+	default:
+		return fmt.Errorf("unknown marker %q", m.Name)
+	}
+
+	return nil
+}
+
+func validationRuleArgs(v interface{}) (rule, message string, err error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return "", "", fmt.Errorf("XValidation needs a {rule, message} value")
+	}
+
+	rule, _ = m["rule"].(string)
+	message, _ = m["message"].(string)
+	if rule == "" {
+		return "", "", fmt.Errorf("XValidation requires a non-empty rule")
+	}
+
+	return rule, message, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func schemaWithSelectorPath() *apiextv1.JSONSchemaProps {
+	return &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"spec": {
+				Type: "object",
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"http": {
+						Type: "array",
+						Items: &apiextv1.JSONSchemaPropsOrArray{
+							Schema: &apiextv1.JSONSchemaProps{
+								Type: "object",
+								Properties: map[string]apiextv1.JSONSchemaProps{
+									"route": {
+										Type: "array",
+										Items: &apiextv1.JSONSchemaPropsOrArray{
+											Schema: &apiextv1.JSONSchemaProps{
+												Type: "object",
+												Properties: map[string]apiextv1.JSONSchemaProps{
+													"destination": {Type: "string"},
+												},
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestApplyMarkersWildcardPath(t *testing.T) {
+	j := schemaWithSelectorPath()
+
+	err := applyMarkers(j, []Marker{{
+		Path:  "spec.http[*].route[*].destination",
+		Name:  "nullable",
+		Value: nil,
+	}})
+	if err != nil {
+		t.Fatalf("applyMarkers: %v", err)
+	}
+
+	dest := j.Properties["spec"].Properties["http"].Items.Schema.Properties["route"].Items.Schema.Properties["destination"]
+	if !dest.Nullable {
+		t.Fatalf("expected destination to be marked nullable, got %+v", dest)
+	}
+}
+
+func TestApplyMarkersListTypeAndMapKey(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"selector": {Type: "array"},
+		},
+	}
+
+	err := applyMarkers(j, []Marker{
+		{Path: "selector", Name: "listType", Value: "map"},
+		{Path: "selector", Name: "listMapKey", Value: "name"},
+	})
+	if err != nil {
+		t.Fatalf("applyMarkers: %v", err)
+	}
+
+	selector := j.Properties["selector"]
+	if selector.XListType == nil || *selector.XListType != "map" {
+		t.Errorf("expected listType map, got %v", selector.XListType)
+	}
+	if len(selector.XListMapKeys) != 1 || selector.XListMapKeys[0] != "name" {
+		t.Errorf("expected listMapKey name, got %v", selector.XListMapKeys)
+	}
+}
+
+func TestApplyMarkersXValidation(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"replicas": {Type: "integer"},
+		},
+	}
+
+	err := applyMarkers(j, []Marker{{
+		Path: "replicas",
+		Name: "XValidation",
+		Value: map[string]interface{}{
+			"rule":    "self <= 100",
+			"message": "replicas must not exceed 100",
+		},
+	}})
+	if err != nil {
+		t.Fatalf("applyMarkers: %v", err)
+	}
+
+	replicas := j.Properties["replicas"]
+	if len(replicas.XValidations) != 1 || replicas.XValidations[0].Rule != "self <= 100" {
+		t.Fatalf("expected XValidation rule to be applied, got %v", replicas.XValidations)
+	}
+}
+
+func TestApplyMarkersUnknownPath(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{Type: "object"}
+
+	err := applyMarkers(j, []Marker{{Path: "missing", Name: "nullable"}})
+	if err == nil {
+		t.Fatalf("expected an error for a marker path that doesn't exist")
+	}
+}
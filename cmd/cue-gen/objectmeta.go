@@ -0,0 +1,114 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/utils/pointer"
+)
+
+const (
+	// dnsSubdomainPattern is the RFC 1123 DNS subdomain validation the
+	// apiserver applies to ObjectMeta.Name for most resources: lowercase
+	// alphanumeric label segments up to 253 characters total, joined by
+	// dots.
+	dnsSubdomainPattern = `^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`
+	// dnsLabelPattern is the RFC 1123 DNS label validation the apiserver
+	// applies to ObjectMeta.Namespace: a single lowercase alphanumeric
+	// segment up to 63 characters.
+	dnsLabelPattern = `^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+)
+
+// embeddedObjectMetaSchema returns the metadata sub-schema spliced into
+// embedded pod/service templates (Sidecar, WorkloadGroup) configured in
+// completeCRD's embeddedObjectMetaPaths, so metadata is validated instead of
+// being let through by x-kubernetes-preserve-unknown-fields. It covers the fields
+// that are actually meaningful on an embedded template: name, namespace,
+// labels and annotations.
+func embeddedObjectMetaSchema() apiextv1.JSONSchemaProps {
+	return apiextv1.JSONSchemaProps{
+		Type:        "object",
+		Description: "Standard object's metadata embedded in the template.",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"name": {
+				Type:      "string",
+				Pattern:   dnsSubdomainPattern,
+				MaxLength: pointer.Int64(253),
+			},
+			"namespace": {
+				Type:      "string",
+				Pattern:   dnsLabelPattern,
+				MaxLength: pointer.Int64(63),
+			},
+			"labels": {
+				Type:                 "object",
+				AdditionalProperties: &apiextv1.JSONSchemaPropsOrBool{Schema: &apiextv1.JSONSchemaProps{Type: "string"}},
+			},
+			"annotations": {
+				Type:                 "object",
+				AdditionalProperties: &apiextv1.JSONSchemaPropsOrBool{Schema: &apiextv1.JSONSchemaProps{Type: "string"}},
+			},
+		},
+	}
+}
+
+// spliceEmbeddedObjectMeta injects a validated `metadata` property at each of
+// paths (dot-separated, relative to j) of the embedded template, e.g.
+// "template" for WorkloadGroup or "ingress.template" for a future nested
+// case. Any x-kubernetes-preserve-unknown-fields on the template is kept.
+func spliceEmbeddedObjectMeta(j *apiextv1.JSONSchemaProps, paths []string) error {
+	for _, path := range paths {
+		if err := spliceAt(j, strings.Split(path, ".")); err != nil {
+			return fmt.Errorf("cannot splice embedded metadata at %q: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func spliceAt(j *apiextv1.JSONSchemaProps, path []string) error {
+	if len(path) == 0 {
+		if j.Properties == nil {
+			j.Properties = map[string]apiextv1.JSONSchemaProps{}
+		}
+		j.Properties["metadata"] = embeddedObjectMetaSchema()
+
+		// patchEmtpyTypesToObjectType stamped x-kubernetes-preserve-unknown-fields
+		// on this node because it had no type at all - it's
+		// an opaque pod/service template CUE couldn't give a static shape.
+		// Leave that flag in place: a declared property is always validated
+		// against its own schema regardless of the flag, so metadata is
+		// already held to embeddedObjectMetaSchema; clearing the flag here
+		// would additionally prune every other field of the template (the
+		// containers, volumes, etc. that make it opaque in the first place).
+
+		return nil
+	}
+
+	field, ok := j.Properties[path[0]]
+	if !ok {
+		return fmt.Errorf("no property %q", path[0])
+	}
+
+	if err := spliceAt(&field, path[1:]); err != nil {
+		return err
+	}
+
+	j.Properties[path[0]] = field
+	return nil
+}
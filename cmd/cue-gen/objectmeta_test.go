@@ -0,0 +1,76 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestEmbeddedObjectMetaSchemaNamePatternMatchesMaxLength(t *testing.T) {
+	name := embeddedObjectMetaSchema().Properties["name"]
+
+	re := regexp.MustCompile(name.Pattern)
+	long := make([]byte, *name.MaxLength)
+	for i := range long {
+		long[i] = 'a'
+	}
+	if !re.MatchString(string(long)) {
+		t.Fatalf("name pattern %q rejects a label of its own max length %d", name.Pattern, *name.MaxLength)
+	}
+
+	namespace := embeddedObjectMetaSchema().Properties["namespace"]
+	if *namespace.MaxLength != 63 {
+		t.Errorf("expected namespace max length 63 (DNS label), got %d", *namespace.MaxLength)
+	}
+	if !regexp.MustCompile(namespace.Pattern).MatchString("my-namespace") {
+		t.Errorf("expected namespace pattern to accept a typical namespace name")
+	}
+}
+
+func TestSpliceEmbeddedObjectMetaPreservesSiblingUnknownFields(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		Type: "object",
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"template": {
+				Type:                   "object",
+				XPreserveUnknownFields: pointer.Bool(true),
+			},
+		},
+	}
+
+	if err := spliceEmbeddedObjectMeta(j, []string{"template"}); err != nil {
+		t.Fatalf("spliceEmbeddedObjectMeta: %v", err)
+	}
+
+	template := j.Properties["template"]
+	if _, ok := template.Properties["metadata"]; !ok {
+		t.Fatalf("expected metadata property to be spliced in, got %+v", template)
+	}
+	if template.XPreserveUnknownFields == nil || !*template.XPreserveUnknownFields {
+		t.Fatalf("expected x-kubernetes-preserve-unknown-fields to survive so the rest of the opaque template isn't pruned, got %v", template.XPreserveUnknownFields)
+	}
+}
+
+func TestSpliceEmbeddedObjectMetaUnknownPath(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{Type: "object"}
+
+	if err := spliceEmbeddedObjectMeta(j, []string{"template"}); err == nil {
+		t.Fatalf("expected an error when the configured path doesn't exist")
+	}
+}
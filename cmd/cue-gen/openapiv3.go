@@ -0,0 +1,103 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	apiext "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/kube-openapi/pkg/spec3"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"sigs.k8s.io/yaml"
+)
+
+// companionOpenAPIV3 builds a standalone OpenAPI v3 document describing c's
+// schemas, one component per version, so tools like kubectl-validate can
+// validate Istio resources - or IDEs and client-side codegen can consume
+// them - without round-tripping through a live apiserver.
+func companionOpenAPIV3(c *apiextv1.CustomResourceDefinition) (*spec3.OpenAPI, error) {
+	doc := &spec3.OpenAPI{
+		Version: "3.0.0",
+		Info: &spec.Info{
+			InfoProps: spec.InfoProps{
+				Title:   fmt.Sprintf("%s.%s", c.Spec.Names.Kind, c.Spec.Group),
+				Version: "generated",
+			},
+		},
+		Components: &spec3.Components{Schemas: map[string]*spec.Schema{}},
+	}
+
+	for _, version := range c.Spec.Versions {
+		if version.Schema == nil || version.Schema.OpenAPIV3Schema == nil {
+			continue
+		}
+
+		s, err := toSpecSchema(version.Schema.OpenAPIV3Schema)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert schema for %v/%v: %v", c.Name, version.Name, err)
+		}
+
+		s.AddExtension("x-kubernetes-group-version-kind", map[string]string{
+			"group":   c.Spec.Group,
+			"version": version.Name,
+			"kind":    c.Spec.Names.Kind,
+		})
+
+		doc.Components.Schemas[fmt.Sprintf("%s.%s.%s", c.Spec.Group, version.Name, c.Spec.Names.Kind)] = s
+	}
+
+	return doc, nil
+}
+
+// companionOpenAPIV3YAML renders c's companion OpenAPI v3 document, ready to
+// be written alongside the CRD's own YAML output.
+func companionOpenAPIV3YAML(c *apiextv1.CustomResourceDefinition) ([]byte, error) {
+	doc, err := companionOpenAPIV3(c)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal OpenAPI v3 document for %v: %v", c.Name, err)
+	}
+
+	return yaml.JSONToYAML(b)
+}
+
+// toSpecSchema converts j - the JSONSchemaProps already built by
+// completeCRD - to kube-openapi's spec.Schema, going through the internal
+// apiextensions type first since that's the conversion structural-schema
+// itself relies on.
+func toSpecSchema(j *apiextv1.JSONSchemaProps) (*spec.Schema, error) {
+	internal := &apiext.JSONSchemaProps{}
+	if err := apiextv1.Convert_v1_JSONSchemaProps_To_apiextensions_JSONSchemaProps(j, internal, nil); err != nil {
+		return nil, err
+	}
+
+	b, err := json.Marshal(internal)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &spec.Schema{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCompanionOpenAPIV3HasGroupVersionKindExtension(t *testing.T) {
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "virtualservices.networking.istio.io"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: "networking.istio.io",
+			Names: apiextv1.CustomResourceDefinitionNames{Kind: "VirtualService"},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{
+				Name: "v1beta1",
+				Schema: &apiextv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextv1.JSONSchemaProps{
+						Type: "object",
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"spec": {Type: "object"},
+						},
+					},
+				},
+			}},
+		},
+	}
+
+	doc, err := companionOpenAPIV3(crd)
+	if err != nil {
+		t.Fatalf("companionOpenAPIV3: %v", err)
+	}
+
+	const key = "networking.istio.io.v1beta1.VirtualService"
+	s, ok := doc.Components.Schemas[key]
+	if !ok {
+		t.Fatalf("expected a component schema keyed %q, got %v", key, doc.Components.Schemas)
+	}
+
+	gvk, ok := s.Extensions["x-kubernetes-group-version-kind"]
+	if !ok {
+		t.Fatalf("expected x-kubernetes-group-version-kind extension, got %v", s.Extensions)
+	}
+	m, ok := gvk.(map[string]string)
+	if !ok || m["kind"] != "VirtualService" || m["version"] != "v1beta1" || m["group"] != "networking.istio.io" {
+		t.Errorf("unexpected group-version-kind extension: %v", gvk)
+	}
+}
+
+func TestCompanionOpenAPIV3YAMLIsValidYAML(t *testing.T) {
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "foos.example.io"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Group: "example.io",
+			Names: apiextv1.CustomResourceDefinitionNames{Kind: "Foo"},
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{
+				Name: "v1",
+				Schema: &apiextv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextv1.JSONSchemaProps{Type: "object"},
+				},
+			}},
+		},
+	}
+
+	b, err := companionOpenAPIV3YAML(crd)
+	if err != nil {
+		t.Fatalf("companionOpenAPIV3YAML: %v", err)
+	}
+	if len(b) == 0 {
+		t.Fatalf("expected non-empty YAML output")
+	}
+}
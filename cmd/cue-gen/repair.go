@@ -0,0 +1,262 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+// repairNonStructural walks s and fixes the non-structural patterns that
+// protobuf-derived CUE routinely produces, returning a human-readable report
+// of what it rewrote (nil if nothing needed fixing). validateStructural still
+// runs afterwards, so a repair that isn't enough still fails the build - this
+// just turns the common cases into a no-op instead of a log.Fatal.
+func repairNonStructural(s *apiextv1.JSONSchemaProps) []string {
+	var report []string
+	repairNode(s, "", &report)
+	return report
+}
+
+func repairNode(j *apiextv1.JSONSchemaProps, path string, report *[]string) {
+	if j == nil {
+		return
+	}
+
+	hoistFromBranches(j, path, report)
+	dropDefaultsFromOneOf(j, path, report)
+	collapseSingletonAllOf(j, path, report)
+	objectifyBareProperties(j, path, report)
+
+	for name, p := range j.Properties {
+		repairNode(&p, childPath(path, name), report)
+		j.Properties[name] = p
+	}
+
+	if j.Items != nil {
+		if j.Items.Schema != nil {
+			repairNode(j.Items.Schema, path+"[]", report)
+		}
+		for i := range j.Items.JSONSchemas {
+			repairNode(&j.Items.JSONSchemas[i], path+"[]", report)
+		}
+	}
+
+	for i := range j.OneOf {
+		repairNode(&j.OneOf[i], path, report)
+	}
+	for i := range j.AnyOf {
+		repairNode(&j.AnyOf[i], path, report)
+	}
+	for i := range j.AllOf {
+		repairNode(&j.AllOf[i], path, report)
+	}
+}
+
+// hoistFromBranches lifts the type/format that every branch of a
+// oneOf/anyOf/allOf setting one agrees on, and nullable if any branch sets
+// it, up to the parent node, which is what the
+// apiserver's structural-schema check requires: branches may only add value
+// constraints, not redeclare the node's type. Once a value is hoisted it's
+// cleared from every branch that carried it, not just the first - otherwise
+// the common protobuf case (every branch repeating the same `type`) leaves
+// the parent *and* every sibling branch still typed, which is still
+// non-structural.
+func hoistFromBranches(j *apiextv1.JSONSchemaProps, path string, report *[]string) {
+	for _, branches := range [][]apiextv1.JSONSchemaProps{j.OneOf, j.AnyOf, j.AllOf} {
+		if len(branches) == 0 {
+			continue
+		}
+
+		if j.Type == "" {
+			if t, ok := agreeingValue(branches, func(b apiextv1.JSONSchemaProps) string { return b.Type }); ok {
+				j.Type = t
+				for i := range branches {
+					if branches[i].Type == t {
+						branches[i].Type = ""
+					}
+				}
+				*report = append(*report, fmt.Sprintf("%s: hoisted type %q out of branches", path, t))
+			}
+		}
+
+		if j.Format == "" {
+			if f, ok := agreeingValue(branches, func(b apiextv1.JSONSchemaProps) string { return b.Format }); ok {
+				j.Format = f
+				for i := range branches {
+					if branches[i].Format == f {
+						branches[i].Format = ""
+					}
+				}
+				*report = append(*report, fmt.Sprintf("%s: hoisted format %q out of branches", path, f))
+			}
+		}
+
+		if !j.Nullable {
+			nullable := false
+			for _, b := range branches {
+				nullable = nullable || b.Nullable
+			}
+			if nullable {
+				j.Nullable = true
+				for i := range branches {
+					branches[i].Nullable = false
+				}
+				*report = append(*report, fmt.Sprintf("%s: hoisted nullable out of branches", path))
+			}
+		}
+	}
+}
+
+// agreeingValue returns the value every branch that sets a non-empty value
+// agrees on, so it's safe to hoist to the parent. Branches that leave the
+// field unset are not considered a disagreement.
+func agreeingValue(branches []apiextv1.JSONSchemaProps, get func(apiextv1.JSONSchemaProps) string) (string, bool) {
+	var v string
+	for _, b := range branches {
+		s := get(b)
+		if s == "" {
+			continue
+		}
+		if v == "" {
+			v = s
+			continue
+		}
+		if v != s {
+			return "", false
+		}
+	}
+	return v, v != ""
+}
+
+// dropDefaultsFromOneOf removes `default` from oneOf branches: the
+// apiserver only allows defaults on the node itself, never inside a oneOf.
+func dropDefaultsFromOneOf(j *apiextv1.JSONSchemaProps, path string, report *[]string) {
+	for i, b := range j.OneOf {
+		if b.Default != nil {
+			j.OneOf[i].Default = nil
+			*report = append(*report, fmt.Sprintf("%s: dropped default from oneOf branch %d", path, i))
+		}
+	}
+}
+
+// collapseSingletonAllOf inlines `allOf: [x]` into the parent node - a
+// single-element allOf is structurally pointless and the apiserver rejects
+// allOf alongside properties/type on the same node in some combinations.
+// The branch's full schema (required, additionalProperties, items, enum,
+// bounds, x-kubernetes-validations, ...) is merged in, not just type and
+// properties, so collapsing never silently drops constraints.
+func collapseSingletonAllOf(j *apiextv1.JSONSchemaProps, path string, report *[]string) {
+	if len(j.AllOf) != 1 {
+		return
+	}
+
+	only := j.AllOf[0]
+	j.AllOf = nil
+
+	merged, err := mergeSchemas(only, *j)
+	if err != nil {
+		*report = append(*report, fmt.Sprintf("%s: could not collapse singleton allOf: %v", path, err))
+		return
+	}
+
+	*j = merged
+	*report = append(*report, fmt.Sprintf("%s: collapsed singleton allOf", path))
+}
+
+// mergeSchemas merges overlay on top of base with overlay's explicitly-set
+// fields winning, field by field; nested objects (e.g. properties) are
+// merged key by key instead of one replacing the other wholesale.
+func mergeSchemas(base, overlay apiextv1.JSONSchemaProps) (apiextv1.JSONSchemaProps, error) {
+	bm, err := toJSONMap(base)
+	if err != nil {
+		return apiextv1.JSONSchemaProps{}, err
+	}
+	om, err := toJSONMap(overlay)
+	if err != nil {
+		return apiextv1.JSONSchemaProps{}, err
+	}
+
+	mb, err := json.Marshal(mergeJSONMaps(bm, om))
+	if err != nil {
+		return apiextv1.JSONSchemaProps{}, err
+	}
+
+	var out apiextv1.JSONSchemaProps
+	if err := json.Unmarshal(mb, &out); err != nil {
+		return apiextv1.JSONSchemaProps{}, err
+	}
+
+	return out, nil
+}
+
+func toJSONMap(s apiextv1.JSONSchemaProps) (map[string]interface{}, error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// mergeJSONMaps recursively merges src into dst, src winning; nested objects
+// are merged key by key, everything else (including slices) is replaced
+// wholesale.
+func mergeJSONMaps(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+
+	for k, sv := range src {
+		if dv, ok := dst[k]; ok {
+			dm, dIsMap := dv.(map[string]interface{})
+			sm, sIsMap := sv.(map[string]interface{})
+			if dIsMap && sIsMap {
+				dst[k] = mergeJSONMaps(dm, sm)
+				continue
+			}
+		}
+		dst[k] = sv
+	}
+
+	return dst
+}
+
+// objectifyBareProperties injects `type: object` on nodes that only carry
+// properties/additionalProperties - CUE drops the type when it's implied by
+// context, but the apiserver requires it to be explicit.
+func objectifyBareProperties(j *apiextv1.JSONSchemaProps, path string, report *[]string) {
+	if j.Type != "" {
+		return
+	}
+	if len(j.Properties) > 0 || j.AdditionalProperties != nil {
+		j.Type = "object"
+		*report = append(*report, fmt.Sprintf("%s: inferred type object", path))
+	}
+}
+
+func childPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+)
+
+func TestHoistFromBranchesMultipleBranches(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		OneOf: []apiextv1.JSONSchemaProps{
+			{Type: "string"},
+			{Type: "string"},
+			{Type: "string"},
+		},
+	}
+
+	var report []string
+	hoistFromBranches(j, "spec.mode", &report)
+
+	if j.Type != "string" {
+		t.Fatalf("expected type to be hoisted to the parent, got %q", j.Type)
+	}
+	for i, b := range j.OneOf {
+		if b.Type != "" {
+			t.Errorf("branch %d still has type %q, want cleared", i, b.Type)
+		}
+	}
+	if len(report) != 1 {
+		t.Errorf("expected exactly one report entry, got %v", report)
+	}
+}
+
+func TestHoistFromBranchesDisagreement(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		OneOf: []apiextv1.JSONSchemaProps{
+			{Type: "string"},
+			{Type: "integer"},
+		},
+	}
+
+	var report []string
+	hoistFromBranches(j, "spec.value", &report)
+
+	if j.Type != "" {
+		t.Fatalf("disagreeing branches must not be hoisted, got parent type %q", j.Type)
+	}
+	if j.OneOf[0].Type != "string" || j.OneOf[1].Type != "integer" {
+		t.Errorf("branch types should be untouched on disagreement, got %+v", j.OneOf)
+	}
+}
+
+func TestCollapseSingletonAllOfPreservesBranchFields(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"name": {Type: "string"},
+		},
+		AllOf: []apiextv1.JSONSchemaProps{
+			{
+				Type:     "object",
+				Required: []string{"name"},
+				Properties: map[string]apiextv1.JSONSchemaProps{
+					"age": {Type: "integer"},
+				},
+			},
+		},
+	}
+
+	var report []string
+	collapseSingletonAllOf(j, "spec.person", &report)
+
+	if j.AllOf != nil {
+		t.Fatalf("allOf should be cleared after collapsing, got %v", j.AllOf)
+	}
+	if j.Type != "object" {
+		t.Errorf("expected type to come from the branch, got %q", j.Type)
+	}
+	if len(j.Required) != 1 || j.Required[0] != "name" {
+		t.Errorf("expected required to be preserved from the branch, got %v", j.Required)
+	}
+	if _, ok := j.Properties["age"]; !ok {
+		t.Errorf("expected branch property %q to be merged in, got %v", "age", j.Properties)
+	}
+	if _, ok := j.Properties["name"]; !ok {
+		t.Errorf("expected parent property %q to survive the merge, got %v", "name", j.Properties)
+	}
+}
+
+func TestDropDefaultsFromOneOf(t *testing.T) {
+	raw := apiextv1.JSON{Raw: []byte(`"x"`)}
+	j := &apiextv1.JSONSchemaProps{
+		OneOf: []apiextv1.JSONSchemaProps{
+			{Type: "string", Default: &raw},
+		},
+	}
+
+	var report []string
+	dropDefaultsFromOneOf(j, "spec.mode", &report)
+
+	if j.OneOf[0].Default != nil {
+		t.Fatalf("expected default to be dropped, got %v", j.OneOf[0].Default)
+	}
+	if len(report) != 1 {
+		t.Errorf("expected exactly one report entry, got %v", report)
+	}
+}
+
+func TestObjectifyBareProperties(t *testing.T) {
+	j := &apiextv1.JSONSchemaProps{
+		Properties: map[string]apiextv1.JSONSchemaProps{
+			"name": {Type: "string"},
+		},
+	}
+
+	var report []string
+	objectifyBareProperties(j, "spec", &report)
+
+	if j.Type != "object" {
+		t.Fatalf("expected type to be inferred as object, got %q", j.Type)
+	}
+	if len(report) != 1 {
+		t.Errorf("expected exactly one report entry, got %v", report)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crdpatch lets users correct generated CRD schemas by hand where the
+// CUE inputs cannot express what's needed. Overlays are applied after the
+// generator has completed a CRD's schema and before it is serialized, so a
+// patch always sees (and must still produce) a valid structural schema.
+package crdpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Overlay is the set of patches to apply for a single CRD version. Both
+// forms are rooted at the whole CustomResourceDefinition, not at the
+// version's schema, so a path like
+// `/spec/versions/0/schema/openAPIV3Schema/properties/spec/properties/values`
+// means what it says.
+type Overlay struct {
+	// JSONPatch is an RFC 6902 JSON Patch document applied to the CRD, e.g.
+	// to mark a oneOf branch or add x-kubernetes-preserve-unknown-fields at
+	// a path cue-gen's marker system doesn't reach.
+	JSONPatch json.RawMessage `json:"jsonPatch,omitempty"`
+	// MergePatch is an RFC 7386 JSON Merge Patch fragment applied to the
+	// CRD before JSONPatch. Maps are merged key by key; any other value -
+	// including a list - replaces the corresponding value wholesale, and a
+	// null value deletes the key, exactly as RFC 7386 defines. A fragment
+	// that touches one element of spec.versions replaces the whole
+	// versions list, so patch the full list rather than relying on a merge
+	// by name.
+	MergePatch map[string]interface{} `json:"mergePatch,omitempty"`
+}
+
+// Config maps a CRD name to the overlay for each of its versions.
+type Config map[string]map[string]Overlay
+
+// Load reads a YAML overlay configuration file. The expected shape is:
+//
+//	<crd name>:
+//	  <version>:
+//	    mergePatch: {...}
+//	    jsonPatch: [...]
+func Load(path string) (Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read overlay file %v: %v", path, err)
+	}
+
+	cfg := Config{}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("cannot parse overlay file %v: %v", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Apply applies the overlays configured for crd.Name to crd, MergePatch
+// first, then JSONPatch, one version's overlay at a time in version order.
+func Apply(cfg Config, crd *apiextv1.CustomResourceDefinition) error {
+	overlays, ok := cfg[crd.Name]
+	if !ok {
+		return nil
+	}
+
+	for _, version := range crd.Spec.Versions {
+		overlay, ok := overlays[version.Name]
+		if !ok {
+			continue
+		}
+
+		if err := applyOverlay(overlay, crd); err != nil {
+			return fmt.Errorf("cannot apply overlay for %v/%v: %v", crd.Name, version.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyOverlay(overlay Overlay, crd *apiextv1.CustomResourceDefinition) error {
+	b, err := json.Marshal(crd)
+	if err != nil {
+		return fmt.Errorf("cannot marshal CRD: %v", err)
+	}
+
+	if len(overlay.MergePatch) > 0 {
+		var doc map[string]interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			return fmt.Errorf("cannot unmarshal CRD for merge: %v", err)
+		}
+		doc = mergePatch(doc, overlay.MergePatch)
+		if b, err = json.Marshal(doc); err != nil {
+			return fmt.Errorf("cannot marshal merged CRD: %v", err)
+		}
+	}
+
+	if len(overlay.JSONPatch) > 0 {
+		patch, err := jsonpatch.DecodePatch(overlay.JSONPatch)
+		if err != nil {
+			return fmt.Errorf("cannot parse JSON Patch: %v", err)
+		}
+		if b, err = patch.Apply(b); err != nil {
+			return fmt.Errorf("cannot apply JSON Patch: %v", err)
+		}
+	}
+
+	out := &apiextv1.CustomResourceDefinition{}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("cannot unmarshal patched CRD: %v", err)
+	}
+
+	*crd = *out
+	return nil
+}
+
+// mergePatch recursively applies src onto dst following RFC 7386 JSON Merge
+// Patch: nested maps are merged key by key, a null value deletes the key
+// from dst, and any other value (including a list) replaces dst's value for
+// that key wholesale - there is no merge-by-key for lists, so a fragment
+// that touches one element of a list must restate the whole list.
+func mergePatch(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = map[string]interface{}{}
+	}
+
+	for k, sv := range src {
+		if sv == nil {
+			delete(dst, k)
+			continue
+		}
+
+		if dv, ok := dst[k]; ok {
+			dm, dIsMap := dv.(map[string]interface{})
+			sm, sIsMap := sv.(map[string]interface{})
+			if dIsMap && sIsMap {
+				dst[k] = mergePatch(dm, sm)
+				continue
+			}
+		}
+		dst[k] = sv
+	}
+
+	return dst
+}
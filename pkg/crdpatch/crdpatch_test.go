@@ -0,0 +1,200 @@
+// Copyright 2019 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crdpatch
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergePatchNestedFragment(t *testing.T) {
+	dst := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"versions": "keep-me",
+		},
+	}
+	src := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"group": "networking.istio.io",
+		},
+	}
+
+	got := mergePatch(dst, src)
+
+	spec, ok := got["spec"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected spec to still be a map, got %T", got["spec"])
+	}
+	if spec["versions"] != "keep-me" {
+		t.Errorf("expected unrelated nested key to survive the merge, got %v", spec["versions"])
+	}
+	if spec["group"] != "networking.istio.io" {
+		t.Errorf("expected nested fragment key to be merged in, got %v", spec["group"])
+	}
+}
+
+func TestMergePatchNullDeletesKey(t *testing.T) {
+	dst := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"group": "networking.istio.io",
+			"scope": "Namespaced",
+		},
+	}
+	src := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"scope": nil,
+		},
+	}
+
+	got := mergePatch(dst, src)
+
+	spec := got["spec"].(map[string]interface{})
+	if _, ok := spec["scope"]; ok {
+		t.Errorf("expected a null patch value to delete the key, got %v", spec["scope"])
+	}
+	if spec["group"] != "networking.istio.io" {
+		t.Errorf("expected unrelated key to survive, got %v", spec["group"])
+	}
+}
+
+func TestMergePatchReplacesListWholesale(t *testing.T) {
+	dst := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"versions": []interface{}{"v1", "v2"},
+		},
+	}
+	src := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"versions": []interface{}{"v3"},
+		},
+	}
+
+	got := mergePatch(dst, src)
+
+	spec := got["spec"].(map[string]interface{})
+	versions := spec["versions"].([]interface{})
+	if len(versions) != 1 || versions[0] != "v3" {
+		t.Errorf("expected the list to be replaced wholesale per RFC 7386, got %v", versions)
+	}
+}
+
+func TestApplyMergePatchNestedFragment(t *testing.T) {
+	cfg, err := configFromYAML(t, `
+Foo:
+  v1:
+    mergePatch:
+      spec:
+        versions:
+        - name: v1
+          schema:
+            openAPIV3Schema:
+              properties:
+                spec:
+                  properties:
+                    values:
+                      type: object
+`)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "Foo"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{
+				Name: "v1",
+				Schema: &apiextv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextv1.JSONSchemaProps{
+						Type: "object",
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"spec": {Type: "object"},
+						},
+					},
+				},
+			}},
+		},
+	}
+
+	if err := Apply(cfg, crd); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	values, ok := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].Properties["values"]
+	if !ok {
+		t.Fatalf("expected nested merge patch fragment to be applied, got %+v", crd.Spec.Versions[0].Schema.OpenAPIV3Schema)
+	}
+	if values.Type != "object" {
+		t.Errorf("expected merged values type to be object, got %q", values.Type)
+	}
+}
+
+func TestApplyJSONPatchRootedAtCRD(t *testing.T) {
+	cfg := Config{
+		"Foo": {
+			"v1": {
+				JSONPatch: json.RawMessage(`[
+					{"op": "replace", "path": "/spec/versions/0/schema/openAPIV3Schema/properties/spec/properties/values/type", "value": "string"}
+				]`),
+			},
+		},
+	}
+
+	crd := &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "Foo"},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Versions: []apiextv1.CustomResourceDefinitionVersion{{
+				Name: "v1",
+				Schema: &apiextv1.CustomResourceValidation{
+					OpenAPIV3Schema: &apiextv1.JSONSchemaProps{
+						Type: "object",
+						Properties: map[string]apiextv1.JSONSchemaProps{
+							"spec": {
+								Type: "object",
+								Properties: map[string]apiextv1.JSONSchemaProps{
+									"values": {Type: "object"},
+								},
+							},
+						},
+					},
+				},
+			}},
+		},
+	}
+
+	if err := Apply(cfg, crd); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	got := crd.Spec.Versions[0].Schema.OpenAPIV3Schema.Properties["spec"].Properties["values"].Type
+	if got != "string" {
+		t.Fatalf("expected JSON Patch path rooted at the CRD to apply, got type %q", got)
+	}
+}
+
+func configFromYAML(t *testing.T, doc string) (Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "overlay.yaml")
+	if err := os.WriteFile(path, []byte(doc), 0o600); err != nil {
+		t.Fatalf("cannot write overlay fixture: %v", err)
+	}
+
+	return Load(path)
+}